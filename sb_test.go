@@ -2,23 +2,29 @@ package sockbench
 
 import (
 	"bytes"
-	"context"
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"os"
+	"runtime"
+	"sync/atomic"
 	"testing"
 )
 
-func BenchmarkSocket(b *testing.B) {
-	benchmarks := map[string]struct {
-		network string // network name -- this is passed to net.Listen
-		address string // address, this is also passed to net.Listen
-		setup   func() // does any setup required by this socket type.  in this case, only used by unix type sockets
-		cleanup func() // does any setup required by this socket type.  in this case, only used by unix type sockets
-	}{
+// socketConfig names a network/address pair that a workload is benchmarked
+// against, plus any setup/cleanup it needs (only used by unix sockets).
+type socketConfig struct {
+	network string // network name -- this is passed to net.Listen
+	address string // address, this is also passed to net.Listen
+	setup   func() // does any setup required by this socket type.  in this case, only used by unix type sockets
+	cleanup func() // does any cleanup required by this socket type.  in this case, only used by unix type sockets
+}
+
+func socketConfigs() map[string]socketConfig {
+	return map[string]socketConfig{
 		"TCP Socket": {
 			network: "tcp",
 			address: ":8889",
@@ -32,7 +38,371 @@ func BenchmarkSocket(b *testing.B) {
 			cleanup: func() { os.Remove("/tmp/test-sock") }, // remove the socket file after the benchmark to prevent a lingering file from causing an error
 		},
 	}
+}
+
+// Workload models the behavior exercised against a connected client/server
+// socket pair for a given payload size.  Implementations own the server's
+// accept-side loop and the client-side loop that b.N times, so that new
+// modes (ping-pong, pipelined, ...) can be added without duplicating the
+// listener/dial setup in runWorkload.
+type Workload interface {
+	// server drives the accepted server-side connection until the client
+	// disconnects.
+	server(c net.Conn, size int64)
+
+	// run executes b.N iterations of the client side of the workload
+	// against a dialed connection.
+	run(b *testing.B, c net.Conn, size int64)
+}
+
+// bufferedConn is implemented by *net.TCPConn and *net.UnixConn, the two
+// conn types runWorkload ever hands out.
+type bufferedConn interface {
+	SetReadBuffer(bytes int) error
+	SetWriteBuffer(bytes int) error
+}
+
+// setConnBuffers sets c's SO_SNDBUF/SO_RCVBUF to bufSize.  bufSize <= 0
+// means "leave the kernel default alone".
+func setConnBuffers(c net.Conn, bufSize int64) error {
+	if bufSize <= 0 {
+		return nil
+	}
+	bc, ok := c.(bufferedConn)
+	if !ok {
+		return fmt.Errorf("sockbench: %T does not support SetReadBuffer/SetWriteBuffer", c)
+	}
+	if err := bc.SetReadBuffer(int(bufSize)); err != nil {
+		return err
+	}
+	return bc.SetWriteBuffer(int(bufSize))
+}
+
+// runWorkload listens and dials bench's network/address pair, starts w's
+// server loop against the accepted connection, and runs w against the
+// dialed connection.  This centralizes the listener/dial setup shared by
+// every workload.  bufSize, if positive, is applied to both the accepted
+// and dialed conns' SO_SNDBUF/SO_RCVBUF before w.run is timed.
+func runWorkload(b *testing.B, bench socketConfig, size int64, w Workload, bufSize int64) {
+	b.Helper()
+
+	bench.setup()
+	defer bench.cleanup()
+
+	// listen on our chosen network type at our specified address
+	l, err := net.Listen(bench.network, bench.address)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if err := setConnBuffers(c, bufSize); err != nil {
+			b.Log(err)
+			return
+		}
+		w.server(c, size)
+	}()
+
+	s, err := net.Dial(bench.network, bench.address)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := setConnBuffers(s, bufSize); err != nil {
+		b.Fatal(err)
+	}
+
+	w.run(b, s, size)
+}
+
+// throughputWorkload measures one-way bulk throughput: the client
+// repeatedly copies a size-byte buffer into the socket, and the server
+// discards whatever it reads.
+type throughputWorkload struct{}
+
+func (throughputWorkload) server(c net.Conn, size int64) {
+	io.Copy(ioutil.Discard, c)
+}
+
+func (throughputWorkload) run(b *testing.B, c net.Conn, size int64) {
+	// make a buffer of random bytes
+	buf := make([]byte, size)
+	rand.Read(buf)
+
+	br := bytes.NewReader(buf)
+	for i := 0; i < b.N; i++ {
+		br.Seek(0, 0)
+		nbytes, err := io.Copy(c, br)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if nbytes != size {
+			b.Fatalf("copied %d of expected %d bytes", nbytes, size)
+		}
+	}
+}
+
+// pingPongWorkload measures round-trip latency: the client writes a
+// size-byte message and waits for it to be echoed back before sending the
+// next one, so b.N counts round trips rather than bytes copied.  This
+// exercises the per-syscall/RTT costs that throughputWorkload's bulk copy
+// hides, which is where UNIX sockets are reported to hold roughly a 3x
+// latency advantage over TCP loopback on IPC-style workloads (sidecars,
+// microservices).
+type pingPongWorkload struct{}
+
+func (pingPongWorkload) server(c net.Conn, size int64) {
+	echo := make([]byte, size)
+	for {
+		if _, err := io.ReadFull(c, echo); err != nil {
+			return
+		}
+		if _, err := c.Write(echo); err != nil {
+			return
+		}
+	}
+}
+
+func (pingPongWorkload) run(b *testing.B, c net.Conn, size int64) {
+	buf := make([]byte, size)
+	rand.Read(buf)
+	reply := make([]byte, size)
+
+	b.SetBytes(size)
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+		nbytes, err := io.ReadFull(c, reply)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if int64(nbytes) != size {
+			b.Fatalf("read %d of expected %d bytes", nbytes, size)
+		}
+	}
+}
+
+// frameHeaderSize is the length, in bytes, of the big-endian length prefix
+// written before every pipelined request/response frame.
+const frameHeaderSize = 4
+
+func writeFrame(w io.Writer, payload []byte) error {
+	var hdr [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(payload)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func readFrame(r io.Reader, payload []byte) error {
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	if n := binary.BigEndian.Uint32(hdr[:]); int(n) != len(payload) {
+		return fmt.Errorf("frame length %d, want %d", n, len(payload))
+	}
+	_, err := io.ReadFull(r, payload)
+	return err
+}
+
+// pipelinedWorkload keeps up to depth length-prefixed requests in flight,
+// writing them while it reads their responses, modelling request/response
+// protocols rather
+// than PingPong's strictly one-at-a-time exchange.  The well-known result
+// is that the UDS-vs-TCP-loopback gap shrinks as pipeline depth grows;
+// sweeping depth lets callers locate that crossover on their hardware.
+type pipelinedWorkload struct {
+	depth int
+}
+
+func (w pipelinedWorkload) server(c net.Conn, size int64) {
+	req := make([]byte, size)
+	for {
+		if err := readFrame(c, req); err != nil {
+			return
+		}
+		if err := writeFrame(c, req); err != nil {
+			return
+		}
+	}
+}
+
+func (w pipelinedWorkload) run(b *testing.B, c net.Conn, size int64) {
+	req := make([]byte, size)
+	rand.Read(req)
+	resp := make([]byte, size)
+
+	b.SetBytes(size * int64(w.depth))
+	for i := 0; i < b.N; i++ {
+		// write depth frames on their own goroutine so this goroutine can
+		// read responses concurrently: once depth*size exceeds the
+		// socket's send/receive buffers, writing every frame before
+		// reading any response deadlocks both sides in Write.
+		writeErr := make(chan error, 1)
+		go func() {
+			for k := 0; k < w.depth; k++ {
+				if err := writeFrame(c, req); err != nil {
+					writeErr <- err
+					return
+				}
+			}
+			writeErr <- nil
+		}()
+
+		for k := 0; k < w.depth; k++ {
+			if err := readFrame(c, resp); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := <-writeErr; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// copyFileWorkload sources io.Copy's input from a file on disk instead of
+// an in-memory buffer, which lets net.Conn's ReadFrom hit the kernel's
+// sendfile(2) fast path instead of the userspace buffer path that
+// throughputWorkload exercises.
+type copyFileWorkload struct{}
+
+func (copyFileWorkload) server(c net.Conn, size int64) {
+	io.Copy(ioutil.Discard, c)
+}
+
+func (copyFileWorkload) run(b *testing.B, c net.Conn, size int64) {
+	f, err := ioutil.TempFile("", "sockbench-copyfile")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	buf := make([]byte, size)
+	rand.Read(buf)
+	if _, err := f.Write(buf); err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		if _, err := f.Seek(0, 0); err != nil {
+			b.Fatal(err)
+		}
+		nbytes, err := io.Copy(c, f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if nbytes != size {
+			b.Fatalf("copied %d of expected %d bytes", nbytes, size)
+		}
+	}
+}
+
+// spliceUpstreamConfig returns the socketConfig for the second, internal
+// loopback connection that copySpliceWorkload reads from.  It has to be
+// the same network as the main connection -- net.Conn's ReadFrom only
+// hits the kernel's splice(2) fast path when both ends are TCPConns or
+// both are UnixConns -- and a distinct address so it doesn't collide with
+// the connection under benchmark.
+func spliceUpstreamConfig(network string) socketConfig {
+	switch network {
+	case "tcp":
+		return socketConfig{network: "tcp", address: ":8891", setup: func() {}, cleanup: func() {}}
+	case "unix":
+		return socketConfig{
+			network: "unix",
+			address: "/tmp/test-sock-splice",
+			setup:   func() { os.Remove("/tmp/test-sock-splice") },
+			cleanup: func() { os.Remove("/tmp/test-sock-splice") },
+		}
+	default:
+		panic("sockbench: no splice upstream for network " + network)
+	}
+}
+
+// copySpliceWorkload sources io.Copy's input from another socket
+// connection of the same network type instead of an in-memory buffer or a
+// file, which lets net.Conn's ReadFrom hit the kernel's splice(2) fast
+// path.
+type copySpliceWorkload struct {
+	network string
+}
+
+func (copySpliceWorkload) server(c net.Conn, size int64) {
+	io.Copy(ioutil.Discard, c)
+}
+
+func (w copySpliceWorkload) run(b *testing.B, c net.Conn, size int64) {
+	upstream := spliceUpstreamConfig(w.network)
+	upstream.setup()
+	defer upstream.cleanup()
+
+	ul, err := net.Listen(upstream.network, upstream.address)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ul.Close()
+
+	go func() {
+		uc, err := ul.Accept()
+		if err != nil {
+			return
+		}
+		defer uc.Close()
+
+		buf := make([]byte, size)
+		rand.Read(buf)
+		for i := 0; i < b.N; i++ {
+			if _, err := uc.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	uc, err := net.Dial(upstream.network, upstream.address)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer uc.Close()
+
+	for i := 0; i < b.N; i++ {
+		nbytes, err := io.Copy(c, io.LimitReader(uc, size))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if nbytes != size {
+			b.Fatalf("copied %d of expected %d bytes", nbytes, size)
+		}
+	}
+}
 
+// BenchmarkSocket compares TCP loopback against UNIX sockets across seven
+// workloads -- Throughput, PingPong, Pipelined, Copy, Datagram,
+// BufferSize and Concurrent -- selected as subtests so each can sweep
+// whatever payload size, pipeline depth, buffer size, or connection count
+// it needs.
+func BenchmarkSocket(b *testing.B) {
+	b.Run("Throughput", benchmarkThroughput)
+	b.Run("PingPong", benchmarkPingPong)
+	b.Run("Pipelined", benchmarkPipelined)
+	b.Run("Copy", benchmarkCopy)
+	b.Run("Datagram", benchmarkDatagram)
+	b.Run("BufferSize", benchmarkBufferSize)
+	b.Run("Concurrent", benchmarkConcurrent)
+}
+
+func benchmarkThroughput(b *testing.B) {
 	// exp is the exponent of the packet size.  we're iterating from 2^1 to 2^30
 	// size packets so illustrate how the size of the packet impacts performance.
 	// my guess is that as the packet sizes increase, the performance gap between
@@ -43,68 +413,342 @@ func BenchmarkSocket(b *testing.B) {
 		// later.
 		size := int64(1) << exp
 
-		// make a buffer of random bytes
+		for name, bench := range socketConfigs() {
+			b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+				b.Run(name, func(b *testing.B) {
+					runWorkload(b, bench, size, throughputWorkload{}, 0)
+				})
+			})
+		}
+	}
+}
+
+func benchmarkPingPong(b *testing.B) {
+	// sizes is the set of small message sizes we ping-pong.  unlike the
+	// throughput sweep, latency is dominated by per-syscall/RTT overhead
+	// rather than payload size, so a handful of representative sizes is
+	// enough to see the trend.
+	for _, size := range []int64{1, 64, 512, 4096} {
+		for name, bench := range socketConfigs() {
+			b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+				b.Run(name, func(b *testing.B) {
+					runWorkload(b, bench, size, pingPongWorkload{}, 0)
+				})
+			})
+		}
+	}
+}
+
+func benchmarkPipelined(b *testing.B) {
+	const size = 4096
+
+	// depth is swept in powers of two from 1 (equivalent to PingPong) to
+	// 128 in-flight requests.
+	for depth := 1; depth <= 128; depth *= 2 {
+		for name, bench := range socketConfigs() {
+			b.Run(fmt.Sprintf("%d", depth), func(b *testing.B) {
+				b.Run(name, func(b *testing.B) {
+					runWorkload(b, bench, size, pipelinedWorkload{depth: depth}, 0)
+				})
+			})
+		}
+	}
+}
+
+func benchmarkCopy(b *testing.B) {
+	// shares the same size sweep as benchmarkThroughput.
+	for exp := 1; exp < 30; exp++ {
+		size := int64(1) << exp
+
+		for name, bench := range socketConfigs() {
+			b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+				b.Run(name, func(b *testing.B) {
+					b.Run("Buffer", func(b *testing.B) {
+						runWorkload(b, bench, size, throughputWorkload{}, 0)
+					})
+					b.Run("File", func(b *testing.B) {
+						runWorkload(b, bench, size, copyFileWorkload{}, 0)
+					})
+					b.Run("Splice", func(b *testing.B) {
+						runWorkload(b, bench, size, copySpliceWorkload{network: bench.network}, 0)
+					})
+				})
+			})
+		}
+	}
+}
+
+// datagramConfig names a connectionless network/address pair.  it mirrors
+// socketConfig but for net.ListenPacket/net.Dial: datagrams have no
+// backlog to Accept from, and unlike streams, not every message sent is
+// guaranteed to arrive.
+type datagramConfig struct {
+	network  string
+	address  string
+	maxDgram int64 // largest payload size this network's sweep covers
+	setup    func()
+	cleanup  func()
+}
+
+func datagramConfigs() map[string]datagramConfig {
+	return map[string]datagramConfig{
+		"UDP": {
+			network:  "udp",
+			address:  ":8892",
+			maxDgram: 1 << 15, // loopback UDP payloads top out around 65507 bytes; stay well clear of it
+			setup:    func() {},
+			cleanup:  func() {},
+		},
+		"Unix Datagram": {
+			network: "unixgram",
+			address: "/tmp/test-sock-dgram",
+			// unixgram has no protocol-imposed cap like UDP's, so sweep well
+			// past it -- runDatagram raises SO_SNDBUF/SO_RCVBUF above the
+			// Linux default (~212992 bytes) to let a single write this big
+			// through.
+			maxDgram: 1 << 20,
+			setup:    func() { os.Remove("/tmp/test-sock-dgram") },
+			cleanup:  func() { os.Remove("/tmp/test-sock-dgram") },
+		},
+	}
+}
+
+// dialDatagram connects to dg's listener, using net.DialUnix for unixgram
+// since it (unlike UDP) is addressed by filesystem path rather than port.
+func dialDatagram(dg datagramConfig) (net.Conn, error) {
+	switch dg.network {
+	case "unixgram":
+		return net.DialUnix("unixgram", nil, &net.UnixAddr{Name: dg.address, Net: "unixgram"})
+	default:
+		return net.Dial(dg.network, dg.address)
+	}
+}
+
+// dgramBufSize is the SO_SNDBUF/SO_RCVBUF applied to unixgram sockets so
+// that runDatagram's sweep can reach datagramConfig.maxDgram: the Linux
+// default (~212992 bytes) otherwise rejects a single write above it with
+// "message too long", well before unixgram hits any protocol limit of its
+// own.
+const dgramBufSize = 4 << 20
+
+// runDatagram sends b.N size-byte datagrams to dg's listener and reports
+// per-message throughput.  Since datagrams aren't guaranteed delivery, a
+// trailing zero-length datagram signals the receiver that the client is
+// done, and the gap between sent and received counts is logged as drops.
+func runDatagram(b *testing.B, dg datagramConfig, size int64) {
+	b.Helper()
+
+	dg.setup()
+	defer dg.cleanup()
+
+	pc, err := net.ListenPacket(dg.network, dg.address)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pc.Close()
+
+	if dg.network == "unixgram" {
+		if conn, ok := pc.(net.Conn); ok {
+			if err := setConnBuffers(conn, dgramBufSize); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	received := make(chan int64, 1)
+	go func() {
+		var n int64
 		buf := make([]byte, size)
-		rand.Read(buf)
+		for {
+			m, _, err := pc.ReadFrom(buf)
+			if err != nil || m == 0 {
+				received <- n
+				return
+			}
+			n++
+		}
+	}()
 
-		br := bytes.NewReader(buf)
-		for name, bench := range benchmarks {
+	c, err := dialDatagram(dg)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.Close()
+
+	if dg.network == "unixgram" {
+		if err := setConnBuffers(c, dgramBufSize); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	buf := make([]byte, size)
+	rand.Read(buf)
+
+	b.SetBytes(size)
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+	c.Write(nil) // zero-length sentinel tells the receiver goroutine we're done
+
+	dropped := int64(b.N) - <-received
+	b.ReportMetric(float64(dropped), "drops")
+	if dropped > 0 {
+		b.Logf("%d of %d datagrams dropped", dropped, b.N)
+	}
+}
+
+func benchmarkDatagram(b *testing.B) {
+	for name, dg := range datagramConfigs() {
+		for exp := 1; int64(1)<<exp <= dg.maxDgram; exp++ {
+			size := int64(1) << exp
 			b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
-				bench.setup()
-				defer bench.cleanup()
+				b.Run(name, func(b *testing.B) {
+					runDatagram(b, dg, size)
+				})
+			})
+		}
+	}
+}
 
-				// listen on our chosen network type at our specified address
-				l, err := net.Listen(bench.network, bench.address)
-				if err != nil {
-					b.Fatal(err)
-				}
-				defer l.Close()
-
-				// a simple network server that accepts one connection and copies all
-				// bytes read from it to /dev/null essentially.
-				//
-				// cancelled by the passed in context.
-				startListener := func(b *testing.B, ctx context.Context, l net.Listener) {
-					b.Helper()
-
-					c, err := l.Accept()
-					if err != nil {
-						b.Log(err)
-						return
-					}
-					for {
-						select {
-						case <-ctx.Done():
-							return
-						default:
-							io.Copy(ioutil.Discard, c)
-						}
-					}
-				}
+// bufferSizes enumerates the SO_SNDBUF/SO_RCVBUF values swept by
+// benchmarkBufferSize.  0 means "leave the kernel default alone".
+var bufferSizes = []int64{0, 4 << 10, 64 << 10, 1 << 20, 4 << 20}
 
-				ctx, cancel := context.WithCancel(context.Background())
-				defer cancel()
+func bufferSizeLabel(bufSize int64) string {
+	if bufSize == 0 {
+		return "default"
+	}
+	return fmt.Sprintf("%d", bufSize)
+}
 
-				go startListener(b, ctx, l)
+// bufferSweepSizes is a handful of representative payload sizes, the way
+// benchmarkPingPong and benchmarkPipelined sweep rather than reusing
+// benchmarkThroughput's exhaustive 2^1..2^29 range: crossed against every
+// bufferSizes entry, a payload much larger than the buffer measures
+// Nagle/delayed-ACK silly-window stalls rather than the knee we're after.
+var bufferSweepSizes = []int64{1 << 10, 1 << 14, 1 << 16, 1 << 18, 1 << 20}
 
-				s, err := net.Dial(bench.network, bench.address)
-				if err != nil {
-					b.Fatal(err)
+// maxPayloadForBufferSize bounds how large a payload is worth timing
+// against bufSize.  0 (kernel default) comfortably covers every size in
+// bufferSweepSizes; an explicit bufSize much smaller than the payload
+// pushes the connection into pathological, effectively non-terminating
+// stalls instead of just running slower.
+func maxPayloadForBufferSize(bufSize int64) int64 {
+	if bufSize <= 0 {
+		return bufferSweepSizes[len(bufferSweepSizes)-1]
+	}
+	return bufSize * 8
+}
+
+// benchmarkBufferSize sweeps {network, payload size, socket buffer size},
+// applying each buffer size to both ends of the connection before timing
+// plain throughput, to locate the knee where a bigger SO_SNDBUF/SO_RCVBUF
+// stops helping.  Combinations where the payload dwarfs the buffer are
+// skipped rather than timed.
+func benchmarkBufferSize(b *testing.B) {
+	for _, size := range bufferSweepSizes {
+		for name, bench := range socketConfigs() {
+			for _, bufSize := range bufferSizes {
+				if size > maxPayloadForBufferSize(bufSize) {
+					b.Logf("skipping %s size=%d bufSize=%s: payload too large for buffer, would stall on Nagle/silly-window", name, size, bufferSizeLabel(bufSize))
+					continue
 				}
-				defer s.Close()
+				b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+					b.Run(name, func(b *testing.B) {
+						b.Run(bufferSizeLabel(bufSize), func(b *testing.B) {
+							runWorkload(b, bench, size, throughputWorkload{}, bufSize)
+						})
+					})
+				})
+			}
+		}
+	}
+}
+
+// runConcurrent pre-dials n connections to bench's network/address pair,
+// then drives them from b.RunParallel -- every parallel goroutine claims
+// a connection round-robin via an atomic counter and writes to it for the
+// duration. This is the only workload that shows how each transport scales
+// under many simultaneous clients: accept-path contention, ephemeral-port
+// pressure on TCP loopback, and inode/backlog behavior for Unix sockets.
+func runConcurrent(b *testing.B, bench socketConfig, size int64, n int) {
+	b.Helper()
+
+	bench.setup()
+	defer bench.cleanup()
+
+	l, err := net.Listen(bench.network, bench.address)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer l.Close()
+
+	// accept loop: every connection gets its own goroutine that discards
+	// whatever it reads, so the client side is free to just write.
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(ioutil.Discard, c)
+			}(c)
+		}
+	}()
+
+	conns := make([]net.Conn, n)
+	for i := range conns {
+		c, err := net.Dial(bench.network, bench.address)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer c.Close()
+		conns[i] = c
+	}
+
+	buf := make([]byte, size)
+	rand.Read(buf)
+
+	var next int64
+
+	// RunParallel's goroutine count is GOMAXPROCS scaled by SetParallelism,
+	// so pin GOMAXPROCS to 1 and ask for exactly n: that's the only way to
+	// guarantee every one of the n dialed connections gets its own
+	// goroutine instead of n mostly-idle connections sharing GOMAXPROCS
+	// writers.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	b.SetParallelism(n)
+
+	b.SetBytes(size)
+	b.ResetTimer() // exclude the n connection dials above from the timed region
+	b.RunParallel(func(pb *testing.PB) {
+		c := conns[atomic.AddInt64(&next, 1)%int64(n)]
+		for pb.Next() {
+			if _, err := c.Write(buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// b.SetBytes already reports aggregate MB/s; add the per-connection
+	// share, which is the one number it can't give us.
+	b.ReportMetric(float64(b.N)*float64(size)/b.Elapsed().Seconds()/1e6/float64(n), "MB/s/conn")
+}
+
+func benchmarkConcurrent(b *testing.B) {
+	const size = 4096
 
+	// n is the number of pre-established connections, one per
+	// RunParallel goroutine.
+	for _, n := range []int{1, 8, 64, 512} {
+		for name, bench := range socketConfigs() {
+			b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
 				b.Run(name, func(b *testing.B) {
-					for i := 0; i < b.N; i++ {
-						br.Seek(0, 0)
-						nbytes, err := io.Copy(s, br)
-
-						if err != nil {
-							b.Fatal(err)
-						}
-						if nbytes != size {
-							b.Fatalf("copied %d of expected %d bytes", nbytes, size)
-						}
-					}
+					runConcurrent(b, bench, size, n)
 				})
 			})
 		}